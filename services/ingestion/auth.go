@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tenantContextKey  = "tenant"
+	subjectContextKey = "subject"
+)
+
+// tenantClaims is the subset of ID token claims this service cares about.
+// Tenant scopes rate limiting; Subject overrides the incoming event's
+// UserID.
+type tenantClaims struct {
+	Subject string `json:"sub"`
+	Tenant  string `json:"tenant"`
+}
+
+// oidcVerifier is nil when auth.issuer is unset, in which case
+// authMiddleware is a no-op. JWKS fetching/caching is handled internally
+// by the oidc package's remote key set.
+var oidcVerifier *oidc.IDTokenVerifier
+
+func initOIDCVerifier(ctx context.Context) error {
+	if config.Auth.Issuer == "" {
+		log.Warn("OIDC issuer not configured, authentication middleware disabled")
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.Auth.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	oidcVerifier = provider.Verifier(&oidc.Config{ClientID: config.Auth.Audience})
+	log.WithField("issuer", config.Auth.Issuer).Info("OIDC verifier initialized")
+	return nil
+}
+
+// authMiddleware validates the bearer token against the configured OIDC
+// issuer (aud/iss/exp are checked by oidcVerifier.Verify), enforces a
+// per-tenant rate limit, and attaches the authenticated subject/tenant to
+// both the gin context and the active span.
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if oidcVerifier == nil {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == "" || token == header {
+			healthEventsErrors.WithLabelValues("auth_missing_token").Inc()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+
+		idToken, err := oidcVerifier.Verify(c.Request.Context(), token)
+		if err != nil {
+			healthEventsErrors.WithLabelValues("auth_invalid_token").Inc()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid bearer token"})
+			return
+		}
+
+		var claims tenantClaims
+		if err := idToken.Claims(&claims); err != nil || claims.Tenant == "" {
+			healthEventsErrors.WithLabelValues("auth_missing_tenant").Inc()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token missing tenant claim"})
+			return
+		}
+
+		if !tenantLimiter.allow(claims.Tenant) {
+			healthEventsErrors.WithLabelValues("rate_limited").Inc()
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Tenant rate limit exceeded"})
+			return
+		}
+
+		span := trace.SpanFromContext(c.Request.Context())
+		span.SetAttributes(
+			attribute.String("tenant.id", claims.Tenant),
+			attribute.String("auth.subject", claims.Subject),
+		)
+
+		c.Set(tenantContextKey, claims.Tenant)
+		c.Set(subjectContextKey, claims.Subject)
+		c.Next()
+	}
+}
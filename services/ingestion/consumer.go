@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// EventHandler processes a decoded HealthEvent consumed from Kafka. Handlers
+// are registered per category so new event types can be wired in without
+// touching the consumer's dispatch logic.
+type EventHandler interface {
+	Handle(ctx context.Context, event HealthEvent) error
+}
+
+var eventHandlers = map[string]EventHandler{}
+
+// RegisterEventHandler wires an EventHandler to handle all consumed
+// messages whose category header matches the given category.
+func RegisterEventHandler(category string, handler EventHandler) {
+	eventHandlers[category] = handler
+}
+
+const consumerMaxRetries = 3
+
+// consumerGroupHandler implements sarama.ConsumerGroupHandler, dispatching
+// each claimed message to the EventHandler registered for its category
+// header and forwarding permanent failures to the dead-letter topic.
+type consumerGroupHandler struct{}
+
+func (consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.dispatch(session.Context(), message)
+			session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+func headerValue(headers []*sarama.RecordHeader, key string) string {
+	for _, header := range headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (h consumerGroupHandler) dispatch(ctx context.Context, message *sarama.ConsumerMessage) {
+	category := headerValue(message.Headers, "category")
+	handler, ok := eventHandlers[category]
+	if !ok {
+		log.WithField("category", category).Warn("No event handler registered for category, dropping message")
+		return
+	}
+
+	var cloudEvent CloudEvent
+	if err := json.Unmarshal(message.Value, &cloudEvent); err != nil {
+		healthEventsErrors.WithLabelValues("consumer_unmarshal").Inc()
+		sendToDeadLetter(message.Topic, message.Value, err.Error())
+		return
+	}
+
+	var err error
+	for attempt := 1; attempt <= consumerMaxRetries; attempt++ {
+		if err = handler.Handle(ctx, cloudEvent.Data); err == nil {
+			return
+		}
+		log.WithError(err).WithFields(logrus.Fields{
+			"topic":    message.Topic,
+			"category": category,
+			"attempt":  attempt,
+		}).Warn("Event handler failed, retrying")
+	}
+
+	healthEventsErrors.WithLabelValues("consumer_handler").Inc()
+	sendToDeadLetter(message.Topic, message.Value, err.Error())
+}
+
+func consumerConfig() *sarama.Config {
+	cfg := sarama.NewConfig()
+	cfg.Consumer.Return.Errors = true
+	cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
+	applySASLConfig(cfg)
+	return cfg
+}
+
+// allTopics returns every topic this service's own consumer group should
+// subscribe to: the static per-category topics, plus every concrete
+// destination reachable via kafka.producer.topic_mapping/default_topic and
+// health.unknown.raw. Without the latter, events routed off the static
+// topics by the topic mapping would never reach the per-category
+// EventHandlers registered in handlers.go. Messages on health.unknown.raw
+// (unmapped FHIR LOINC codes carry category "unknown") are only handled if
+// an EventHandler is registered for their category; handlers.go registers
+// none for "unknown", so dispatch drops them.
+//
+// dead_letter_topic is deliberately excluded: sendToDeadLetter can forward
+// payloads this consumer's dispatch can't parse (e.g. the json_marshal
+// failure path dead-letters with no CloudEvents JSON at all), and dispatch
+// re-dead-letters anything it fails to unmarshal to message.Topic - if that
+// topic were the DLQ itself, this consumer would republish the message
+// back into the topic it just read it from and immediately reprocess it,
+// looping forever. The DLQ is for downstream repair tooling, not this
+// service's own consumer.
+func allTopics() []string {
+	t := config.Kafka.Topics
+	topics := []string{t.Activity, t.HeartRate, t.Sleep, t.Nutrition, t.Weight, t.Mood, t.Hydration, t.Meditation}
+
+	seen := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		seen[topic] = true
+	}
+
+	extra := make([]string, 0, len(config.Kafka.Producer.TopicMapping)+2)
+	for _, topic := range config.Kafka.Producer.TopicMapping {
+		extra = append(extra, topic)
+	}
+	if config.Kafka.Producer.DefaultTopic != "" {
+		extra = append(extra, config.Kafka.Producer.DefaultTopic)
+	}
+	extra = append(extra, "health.unknown.raw")
+
+	for _, topic := range extra {
+		if topic != "" && !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// runConsumer subscribes to the configured health topics and dispatches
+// messages until ctx is canceled, calling wg.Done() once it has fully
+// stopped. Callers cancel ctx and then wg.Wait() before closing the Kafka
+// client, mirroring the rest of the service's shutdown sequence.
+func runConsumer(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	group, err := sarama.NewConsumerGroup(config.Kafka.Brokers, config.Kafka.Consumer.GroupID, consumerConfig())
+	if err != nil {
+		log.WithError(err).Error("Failed to create Kafka consumer group")
+		return
+	}
+	defer group.Close()
+
+	topics := allTopics()
+	handler := consumerGroupHandler{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if err := group.Consume(ctx, topics, handler); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.WithError(err).Error("Consumer group session ended with error")
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	<-done
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthEvent represents a health data event
+type HealthEvent struct {
+	ID        string                 `json:"id"`
+	UserID    string                 `json:"user_id"`
+	EventType string                 `json:"event_type"`
+	Category  string                 `json:"category"`
+	Value     map[string]interface{} `json:"value"`
+	Timestamp time.Time              `json:"timestamp"`
+	Source    string                 `json:"source"`
+	DeviceID  string                 `json:"device_id,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func (e HealthEvent) validate() error {
+	if e.UserID == "" || e.EventType == "" || e.Category == "" {
+		return fmt.Errorf("missing required fields")
+	}
+	return nil
+}
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventSource       = "health-and-wellness/ingestion-service"
+	cloudEventContentType  = "application/json"
+)
+
+// CloudEvent is a CloudEvents v1.0 envelope around a HealthEvent, with
+// health-domain extension attributes so consumers can filter/route without
+// unmarshaling the data payload.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	ID              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	UserID          string      `json:"userid"`
+	DeviceID        string      `json:"deviceid,omitempty"`
+	Category        string      `json:"category"`
+	Data            HealthEvent `json:"data"`
+}
+
+// toCloudEvent wraps a HealthEvent in a CloudEvents envelope. The event's
+// ID and CreatedAt are expected to already be populated by the caller.
+func toCloudEvent(event HealthEvent) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            fmt.Sprintf("com.healthwellness.%s.%s", event.Category, event.EventType),
+		Source:          cloudEventSource,
+		ID:              event.ID,
+		Time:            event.CreatedAt,
+		DataContentType: cloudEventContentType,
+		UserID:          event.UserID,
+		DeviceID:        event.DeviceID,
+		Category:        event.Category,
+		Data:            event,
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LoincMapping routes a LOINC code to the internal event taxonomy. It is
+// config-driven (fhir.loinc_mapping) so new codes can be added without a
+// recompile.
+type LoincMapping struct {
+	Category  string `mapstructure:"category"`
+	EventType string `mapstructure:"event_type"`
+}
+
+// Minimal FHIR R4 Observation subset: only the elements this service
+// needs to validate and normalize into a HealthEvent.
+
+type fhirCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type fhirCodeableConcept struct {
+	Coding []fhirCoding `json:"coding"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type fhirReference struct {
+	Reference string `json:"reference"`
+}
+
+type fhirQuantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+	System string  `json:"system,omitempty"`
+	Code   string  `json:"code,omitempty"`
+}
+
+type fhirPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end,omitempty"`
+}
+
+type fhirObservation struct {
+	ResourceType         string               `json:"resourceType"`
+	Status               string               `json:"status"`
+	Code                 fhirCodeableConcept  `json:"code"`
+	Subject              fhirReference        `json:"subject"`
+	EffectiveDateTime    string               `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod      *fhirPeriod          `json:"effectivePeriod,omitempty"`
+	ValueQuantity        *fhirQuantity        `json:"valueQuantity,omitempty"`
+	ValueCodeableConcept *fhirCodeableConcept `json:"valueCodeableConcept,omitempty"`
+	ValueString          string               `json:"valueString,omitempty"`
+}
+
+func (o fhirObservation) validate() error {
+	if o.Status == "" {
+		return fmt.Errorf("missing required element: status")
+	}
+	if len(o.Code.Coding) == 0 {
+		return fmt.Errorf("missing required element: code")
+	}
+	if o.Subject.Reference == "" {
+		return fmt.Errorf("missing required element: subject")
+	}
+	if o.EffectiveDateTime == "" && o.EffectivePeriod == nil {
+		return fmt.Errorf("missing required element: effective[x]")
+	}
+	if o.ValueQuantity == nil && o.ValueCodeableConcept == nil && o.ValueString == "" {
+		return fmt.Errorf("missing required element: value[x]")
+	}
+	return nil
+}
+
+func (o fhirObservation) effectiveTimestamp() time.Time {
+	raw := o.EffectiveDateTime
+	if raw == "" && o.EffectivePeriod != nil {
+		raw = o.EffectivePeriod.Start
+	}
+	if raw == "" {
+		return time.Time{}
+	}
+	if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+		return ts
+	}
+	return time.Time{}
+}
+
+// mapLoincCode looks up the first coding with a configured LOINC mapping,
+// returning the matched coding alongside it. When no coding is mapped it
+// returns the first coding instead, so callers can preserve it on the
+// unmapped path.
+func mapLoincCode(concept fhirCodeableConcept) (LoincMapping, fhirCoding, bool) {
+	for _, coding := range concept.Coding {
+		if mapping, ok := config.FHIR.LoincMapping[coding.Code]; ok {
+			return mapping, coding, true
+		}
+	}
+
+	var first fhirCoding
+	if len(concept.Coding) > 0 {
+		first = concept.Coding[0]
+	}
+	return LoincMapping{}, first, false
+}
+
+// observationToHealthEvent normalizes a FHIR Observation into the internal
+// HealthEvent shape, reporting whether its code had a configured LOINC
+// mapping.
+func observationToHealthEvent(obs fhirObservation) (HealthEvent, fhirCoding, bool) {
+	mapping, coding, matched := mapLoincCode(obs.Code)
+
+	event := HealthEvent{
+		UserID: strings.TrimPrefix(obs.Subject.Reference, "Patient/"),
+		Source: "fhir",
+		Value:  map[string]interface{}{},
+	}
+
+	if ts := obs.effectiveTimestamp(); !ts.IsZero() {
+		event.Timestamp = ts
+	} else {
+		event.Timestamp = time.Now()
+	}
+
+	switch {
+	case obs.ValueQuantity != nil:
+		event.Value["value"] = obs.ValueQuantity.Value
+		event.Value["unit"] = obs.ValueQuantity.Unit
+	case obs.ValueCodeableConcept != nil:
+		event.Value["value"] = obs.ValueCodeableConcept.Text
+	case obs.ValueString != "":
+		event.Value["value"] = obs.ValueString
+	}
+
+	if matched {
+		event.Category = mapping.Category
+		event.EventType = mapping.EventType
+	} else {
+		event.Category = "unknown"
+		event.EventType = "unknown"
+	}
+
+	return event, coding, matched
+}
+
+// publishFHIRObservation validates and normalizes a FHIR Observation,
+// publishing it as a CloudEvent keyed by "Patient/{id}". Unmapped LOINC
+// codes are routed to health.unknown.raw with the original coding
+// preserved as headers.
+func publishFHIRObservation(obs fhirObservation) (string, error) {
+	if err := obs.validate(); err != nil {
+		healthEventsErrors.WithLabelValues("fhir_validation").Inc()
+		return "", fmt.Errorf("invalid FHIR Observation: %w", err)
+	}
+
+	event, coding, matched := observationToHealthEvent(obs)
+	event.ID = uuid.New().String()
+	event.CreatedAt = time.Now()
+	healthEventsReceived.WithLabelValues(event.EventType, event.Source).Inc()
+
+	cloudEvent := toCloudEvent(event)
+	eventJSON, err := json.Marshal(cloudEvent)
+	if err != nil {
+		healthEventsErrors.WithLabelValues("json_marshal").Inc()
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	topic := resolveTopic(event)
+	headers := []sarama.RecordHeader{
+		{Key: []byte("ce_type"), Value: []byte(cloudEvent.Type)},
+		{Key: []byte("category"), Value: []byte(event.Category)},
+		{Key: []byte("source"), Value: []byte(event.Source)},
+	}
+
+	if !matched {
+		topic = "health.unknown.raw"
+		headers = append(headers,
+			sarama.RecordHeader{Key: []byte("fhir_system"), Value: []byte(coding.System)},
+			sarama.RecordHeader{Key: []byte("fhir_code"), Value: []byte(coding.Code)},
+		)
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.StringEncoder(obs.Subject.Reference),
+		Value:   sarama.ByteEncoder(eventJSON),
+		Headers: headers,
+		Metadata: &messageMetadata{
+			enqueuedAt: time.Now(),
+			eventID:    event.ID,
+			eventType:  event.EventType,
+		},
+	}
+
+	select {
+	case publishQueue <- message:
+		queueDepthGauge.Set(float64(len(publishQueue)))
+	default:
+		healthEventsErrors.WithLabelValues("queue_full").Inc()
+		sendToDeadLetter(topic, eventJSON, ErrQueueFull.Error())
+		return "", ErrQueueFull
+	}
+
+	return event.ID, nil
+}
+
+type fhirBundleEntry struct {
+	Resource json.RawMessage `json:"resource"`
+}
+
+type fhirBundle struct {
+	ResourceType string            `json:"resourceType"`
+	Type         string            `json:"type"`
+	Entry        []fhirBundleEntry `json:"entry"`
+}
+
+// fhirObservationHandler accepts either a single FHIR Observation or a
+// Bundle of type transaction/collection containing Observations, and
+// publishes each as a separate Kafka message.
+func fhirObservationHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	var envelope struct {
+		ResourceType string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+		return
+	}
+
+	switch envelope.ResourceType {
+	case "Bundle":
+		handleFHIRBundle(c, body)
+	case "Observation":
+		handleFHIRObservation(c, body)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a FHIR Observation or Bundle resource"})
+	}
+}
+
+func handleFHIRObservation(c *gin.Context, body []byte) {
+	var obs fhirObservation
+	if err := json.Unmarshal(body, &obs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Observation resource"})
+		return
+	}
+
+	eventID, err := publishFHIRObservation(obs)
+	if err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Publish queue is full, retry shortly"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Observation accepted for publishing",
+		"event_id": eventID,
+	})
+}
+
+func handleFHIRBundle(c *gin.Context, body []byte) {
+	var bundle fhirBundle
+	if err := json.Unmarshal(body, &bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Bundle resource"})
+		return
+	}
+
+	if bundle.Type != "transaction" && bundle.Type != "collection" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bundle.type must be transaction or collection"})
+		return
+	}
+
+	results := make([]gin.H, 0, len(bundle.Entry))
+	for _, entry := range bundle.Entry {
+		var resourceEnvelope struct {
+			ResourceType string `json:"resourceType"`
+		}
+		if err := json.Unmarshal(entry.Resource, &resourceEnvelope); err != nil || resourceEnvelope.ResourceType != "Observation" {
+			results = append(results, gin.H{"error": "bundle entry is not an Observation resource"})
+			continue
+		}
+
+		var obs fhirObservation
+		if err := json.Unmarshal(entry.Resource, &obs); err != nil {
+			results = append(results, gin.H{"error": "invalid Observation resource"})
+			continue
+		}
+
+		eventID, err := publishFHIRObservation(obs)
+		if err != nil {
+			results = append(results, gin.H{"error": err.Error()})
+			continue
+		}
+		results = append(results, gin.H{"event_id": eventID, "status": "accepted"})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func validObservation() fhirObservation {
+	return fhirObservation{
+		ResourceType:      "Observation",
+		Status:            "final",
+		Code:              fhirCodeableConcept{Coding: []fhirCoding{{System: "http://loinc.org", Code: "8867-4"}}},
+		Subject:           fhirReference{Reference: "Patient/123"},
+		EffectiveDateTime: "2026-07-26T10:00:00Z",
+		ValueQuantity:     &fhirQuantity{Value: 72, Unit: "bpm"},
+	}
+}
+
+func TestFhirObservationValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(o *fhirObservation)
+		wantErr bool
+	}{
+		{name: "valid observation", mutate: func(o *fhirObservation) {}, wantErr: false},
+		{name: "missing status", mutate: func(o *fhirObservation) { o.Status = "" }, wantErr: true},
+		{name: "missing code", mutate: func(o *fhirObservation) { o.Code = fhirCodeableConcept{} }, wantErr: true},
+		{name: "missing subject", mutate: func(o *fhirObservation) { o.Subject = fhirReference{} }, wantErr: true},
+		{
+			name: "missing effective[x]",
+			mutate: func(o *fhirObservation) {
+				o.EffectiveDateTime = ""
+				o.EffectivePeriod = nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "effectivePeriod satisfies effective[x]",
+			mutate: func(o *fhirObservation) {
+				o.EffectiveDateTime = ""
+				o.EffectivePeriod = &fhirPeriod{Start: "2026-07-26T10:00:00Z"}
+			},
+			wantErr: false,
+		},
+		{
+			name: "missing value[x]",
+			mutate: func(o *fhirObservation) {
+				o.ValueQuantity = nil
+				o.ValueCodeableConcept = nil
+				o.ValueString = ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "valueString satisfies value[x]",
+			mutate: func(o *fhirObservation) {
+				o.ValueQuantity = nil
+				o.ValueString = "normal"
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obs := validObservation()
+			tt.mutate(&obs)
+			err := obs.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func withLoincMapping(t *testing.T, mapping map[string]LoincMapping) {
+	t.Helper()
+	orig := config.FHIR.LoincMapping
+	config.FHIR.LoincMapping = mapping
+	t.Cleanup(func() { config.FHIR.LoincMapping = orig })
+}
+
+func TestObservationToHealthEventMappedCode(t *testing.T) {
+	withLoincMapping(t, map[string]LoincMapping{
+		"8867-4": {Category: "heart_rate", EventType: "heart_rate.reading"},
+	})
+
+	obs := validObservation()
+	event, coding, matched := observationToHealthEvent(obs)
+
+	if !matched {
+		t.Fatal("expected LOINC code 8867-4 to match configured mapping")
+	}
+	if event.Category != "heart_rate" || event.EventType != "heart_rate.reading" {
+		t.Errorf("event = %+v, want category/event_type from mapping", event)
+	}
+	if coding.Code != "8867-4" {
+		t.Errorf("coding.Code = %q, want %q", coding.Code, "8867-4")
+	}
+	if event.UserID != "123" {
+		t.Errorf("event.UserID = %q, want %q", event.UserID, "123")
+	}
+}
+
+func TestObservationToHealthEventUnmappedCodeRoutesToUnknown(t *testing.T) {
+	withLoincMapping(t, map[string]LoincMapping{})
+
+	obs := validObservation()
+	event, coding, matched := observationToHealthEvent(obs)
+
+	if matched {
+		t.Fatal("expected an unconfigured LOINC code to not match")
+	}
+	if event.Category != "unknown" || event.EventType != "unknown" {
+		t.Errorf("event = %+v, want category/event_type \"unknown\"", event)
+	}
+	if coding.Code != "8867-4" {
+		t.Errorf("coding.Code = %q, want original coding preserved (%q)", coding.Code, "8867-4")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loggingEventHandler is the default EventHandler registered for every
+// category: it simply logs the consumed event. Handlers that act on the
+// data (alerting, persistence, aggregation, ...) register themselves
+// under the same category to replace it.
+type loggingEventHandler struct {
+	category string
+}
+
+func (h loggingEventHandler) Handle(ctx context.Context, event HealthEvent) error {
+	log.WithFields(logrus.Fields{
+		"event_id":   event.ID,
+		"user_id":    event.UserID,
+		"event_type": event.EventType,
+		"category":   h.category,
+	}).Info("Consumed health event")
+	return nil
+}
+
+func init() {
+	for _, category := range []string{
+		"activity", "heart_rate", "sleep", "nutrition",
+		"weight", "mood", "hydration", "meditation",
+	} {
+		RegisterEventHandler(category, loggingEventHandler{category: category})
+	}
+}
@@ -2,17 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/Shopify/sarama"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
@@ -63,19 +62,6 @@ var (
 	)
 )
 
-// HealthEvent represents a health data event
-type HealthEvent struct {
-	ID          string                 `json:"id"`
-	UserID      string                 `json:"user_id"`
-	EventType   string                 `json:"event_type"`
-	Category    string                 `json:"category"`
-	Value       map[string]interface{} `json:"value"`
-	Timestamp   time.Time              `json:"timestamp"`
-	Source      string                 `json:"source"`
-	DeviceID    string                 `json:"device_id,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
-}
-
 // Config holds application configuration
 type Config struct {
 	Server struct {
@@ -94,17 +80,56 @@ type Config struct {
 			Hydration  string `mapstructure:"hydration"`
 			Meditation string `mapstructure:"meditation"`
 		} `mapstructure:"topics"`
+		Producer struct {
+			// TopicMapping routes events to topics by event_type, with
+			// support for path.Match-style wildcards (e.g. "health.activity.*").
+			TopicMapping    map[string]string `mapstructure:"topic_mapping"`
+			DefaultTopic    string            `mapstructure:"default_topic"`
+			DeadLetterTopic string            `mapstructure:"dead_letter_topic"`
+
+			// QueueCapacity bounds the in-memory queue fronting the async
+			// producer; BatchSize/Linger control how the dispatcher pool
+			// groups messages by topic before handing them to Kafka.
+			QueueCapacity        int           `mapstructure:"queue_capacity"`
+			BatchSize            int           `mapstructure:"batch_size"`
+			Linger               time.Duration `mapstructure:"linger"`
+			Dispatchers          int           `mapstructure:"dispatchers"`
+			ShutdownDrainTimeout time.Duration `mapstructure:"shutdown_drain_timeout"`
+		} `mapstructure:"producer"`
+		Consumer struct {
+			GroupID string `mapstructure:"group_id"`
+		} `mapstructure:"consumer"`
+		SASL struct {
+			Enabled   bool   `mapstructure:"enabled"`
+			Mechanism string `mapstructure:"mechanism"`
+			User      string `mapstructure:"user"`
+			Password  string `mapstructure:"password"`
+		} `mapstructure:"sasl"`
 	} `mapstructure:"kafka"`
-	
+
 	Jaeger struct {
 		Endpoint string `mapstructure:"endpoint"`
 	} `mapstructure:"jaeger"`
+
+	Auth struct {
+		Issuer    string `mapstructure:"issuer"`
+		Audience  string `mapstructure:"audience"`
+		RateLimit struct {
+			RPS   float64 `mapstructure:"rps"`
+			Burst int     `mapstructure:"burst"`
+		} `mapstructure:"rate_limit"`
+	} `mapstructure:"auth"`
+
+	FHIR struct {
+		// LoincMapping maps a LOINC code to the internal category/event_type
+		// taxonomy; codes with no entry route to health.unknown.raw.
+		LoincMapping map[string]LoincMapping `mapstructure:"loinc_mapping"`
+	} `mapstructure:"fhir"`
 }
 
 var (
 	config Config
-	producer sarama.SyncProducer
-	tracer   trace.Tracer
+	tracer trace.Tracer
 )
 
 func init() {
@@ -131,12 +156,27 @@ func init() {
 	viper.SetDefault("kafka.topics.mood", "health.mood.raw")
 	viper.SetDefault("kafka.topics.hydration", "health.hydration.raw")
 	viper.SetDefault("kafka.topics.meditation", "health.meditation.raw")
+	viper.SetDefault("kafka.consumer.group_id", "ingestion-service")
+	viper.SetDefault("kafka.producer.queue_capacity", 10000)
+	viper.SetDefault("kafka.producer.batch_size", 100)
+	viper.SetDefault("kafka.producer.linger", "100ms")
+	viper.SetDefault("kafka.producer.dispatchers", 4)
+	viper.SetDefault("kafka.producer.shutdown_drain_timeout", "10s")
+	viper.SetDefault("kafka.sasl.enabled", false)
+	viper.SetDefault("kafka.sasl.mechanism", "SCRAM-SHA-512")
 	viper.SetDefault("jaeger.endpoint", "http://localhost:14268/api/traces")
-	
+	viper.SetDefault("auth.rate_limit.rps", 50)
+	viper.SetDefault("auth.rate_limit.burst", 100)
+	viper.SetDefault("fhir.loinc_mapping", map[string]interface{}{
+		"8867-4":  map[string]interface{}{"category": "heart_rate", "event_type": "heart_rate"},
+		"55423-8": map[string]interface{}{"category": "activity", "event_type": "activity"},
+		"29463-7": map[string]interface{}{"category": "weight", "event_type": "weight"},
+	})
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Warnf("Failed to read config file: %v", err)
 	}
-	
+
 	if err := viper.Unmarshal(&config); err != nil {
 		log.Fatalf("Failed to unmarshal config: %v", err)
 	}
@@ -150,6 +190,13 @@ func init() {
 	if err := initKafkaProducer(); err != nil {
 		log.Fatalf("Failed to initialize Kafka producer: %v", err)
 	}
+
+	tenantLimiter.configure(config.Auth.RateLimit.RPS, config.Auth.RateLimit.Burst)
+
+	// Initialize OIDC verifier (no-op if auth.issuer is unset)
+	if err := initOIDCVerifier(context.Background()); err != nil {
+		log.Fatalf("Failed to initialize OIDC verifier: %v", err)
+	}
 }
 
 func initTracer() error {
@@ -175,118 +222,51 @@ func initTracer() error {
 	
 	otel.SetTracerProvider(tp)
 	tracer = tp.Tracer("ingestion-service")
-	
-	return nil
-}
 
-func initKafkaProducer() error {
-	kafkaConfig := sarama.NewConfig()
-	kafkaConfig.Producer.Return.Successes = true
-	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
-	kafkaConfig.Producer.Retry.Max = 5
-	
-	var err error
-	producer, err = sarama.NewSyncProducer(config.Kafka.Brokers, kafkaConfig)
-	if err != nil {
-		return err
-	}
-	
-	log.Info("Kafka producer initialized successfully")
+	// Mark Jaeger unhealthy for /readyz on any OpenTelemetry error,
+	// including a failed span export.
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.WithError(err).Error("OpenTelemetry error")
+		jaegerHealthy.Store(false)
+	}))
+	jaegerHealthy.Store(true)
+
 	return nil
 }
 
-func getTopicForCategory(category string) string {
-	switch category {
-	case "activity":
-		return config.Kafka.Topics.Activity
-	case "heart_rate":
-		return config.Kafka.Topics.HeartRate
-	case "sleep":
-		return config.Kafka.Topics.Sleep
-	case "nutrition":
-		return config.Kafka.Topics.Nutrition
-	case "weight":
-		return config.Kafka.Topics.Weight
-	case "mood":
-		return config.Kafka.Topics.Mood
-	case "hydration":
-		return config.Kafka.Topics.Hydration
-	case "meditation":
-		return config.Kafka.Topics.Meditation
-	default:
-		return "health.unknown.raw"
-	}
-}
+// tracingMiddleware starts the single span that covers an ingestion
+// request, attaching it to the gin request's context so that
+// authMiddleware can annotate it with the authenticated tenant/subject
+// and handlers can reuse it instead of starting a disconnected span of
+// their own.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, span := tracer.Start(c.Request.Context(), c.FullPath())
+		defer span.End()
 
-func publishHealthEvent(ctx context.Context, event HealthEvent) error {
-	span := trace.SpanFromContext(ctx)
-	defer span.End()
-	
-	// Increment received events metric
-	healthEventsReceived.WithLabelValues(event.EventType, event.Source).Inc()
-	
-	// Prepare event for Kafka
-	event.ID = uuid.New().String()
-	event.CreatedAt = time.Now()
-	
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		healthEventsErrors.WithLabelValues("json_marshal").Inc()
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
-	
-	// Determine Kafka topic
-	topic := getTopicForCategory(event.Category)
-	
-	// Create Kafka message
-	message := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(event.UserID),
-		Value: sarama.ByteEncoder(eventJSON),
-		Headers: []sarama.RecordHeader{
-			{Key: []byte("event_type"), Value: []byte(event.EventType)},
-			{Key: []byte("category"), Value: []byte(event.Category)},
-			{Key: []byte("source"), Value: []byte(event.Source)},
-			{Key: []byte("timestamp"), Value: []byte(event.Timestamp.Format(time.RFC3339))},
-		},
-	}
-	
-	// Publish to Kafka
-	partition, offset, err := producer.SendMessage(message)
-	if err != nil {
-		healthEventsErrors.WithLabelValues("kafka_publish").Inc()
-		return fmt.Errorf("failed to publish to Kafka: %w", err)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
 	}
-	
-	// Increment published events metric
-	healthEventsPublished.WithLabelValues(topic, event.EventType).Inc()
-	
-	log.WithFields(logrus.Fields{
-		"event_id":  event.ID,
-		"user_id":   event.UserID,
-		"event_type": event.EventType,
-		"category":  event.Category,
-		"topic":     topic,
-		"partition": partition,
-		"offset":    offset,
-	}).Info("Health event published to Kafka")
-	
-	return nil
 }
 
 func healthEventHandler(c *gin.Context) {
 	start := time.Now()
-	
-	ctx, span := tracer.Start(c.Request.Context(), "health_event_handler")
-	defer span.End()
-	
+
+	ctx := c.Request.Context()
+
 	var event HealthEvent
 	if err := c.ShouldBindJSON(&event); err != nil {
 		healthEventsErrors.WithLabelValues("invalid_json").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
 		return
 	}
-	
+
+	// An authenticated request's token is the source of truth for whose
+	// event this is, overriding whatever the client put in the payload.
+	if subject, ok := c.Get(subjectContextKey); ok {
+		event.UserID = subject.(string)
+	}
+
 	// Validate required fields
 	if event.UserID == "" || event.EventType == "" || event.Category == "" {
 		healthEventsErrors.WithLabelValues("missing_fields").Inc()
@@ -299,28 +279,26 @@ func healthEventHandler(c *gin.Context) {
 		event.Timestamp = time.Now()
 	}
 	
-	// Publish event to Kafka
+	// Enqueue the event; publishing to Kafka happens asynchronously on the
+	// dispatcher pool.
 	if err := publishHealthEvent(ctx, event); err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Publish queue is full, retry shortly"})
+			return
+		}
 		log.WithError(err).Error("Failed to publish health event")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
 		return
 	}
-	
+
 	// Record request duration
 	duration := time.Since(start).Seconds()
-	requestDuration.WithLabelValues("POST", "/health/event", "200").Observe(duration)
-	
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Health event processed successfully",
-		"event_id": event.ID,
-	})
-}
+	requestDuration.WithLabelValues("POST", "/health/event", "202").Observe(duration)
 
-func healthCheckHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "healthy",
-		"service": "ingestion-service",
-		"timestamp": time.Now().UTC(),
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Health event accepted for publishing",
+		"event_id": event.ID,
 	})
 }
 
@@ -339,23 +317,39 @@ func setupRouter() *gin.Engine {
 		requestDuration.WithLabelValues(c.Request.Method, c.FullPath(), fmt.Sprintf("%d", c.Writer.Status())).Observe(duration)
 	})
 	
-	// Health check endpoint
-	router.GET("/health", healthCheckHandler)
+	// Liveness/readiness endpoints
+	router.GET("/livez", livezHandler)
+	router.GET("/readyz", readyzHandler)
 	
 	// Metrics endpoint for Prometheus
 	router.GET("/metrics", metricsHandler)
 	
+	// Ingestion endpoints require an authenticated, rate-limited tenant
+	// (no-op when auth.issuer is unset). tracingMiddleware runs first so
+	// authMiddleware has a real span to annotate with the tenant/subject.
+	ingestion := router.Group("/health")
+	ingestion.Use(tracingMiddleware(), authMiddleware())
+
 	// Health event ingestion endpoint
-	router.POST("/health/event", healthEventHandler)
-	
+	ingestion.POST("/event", healthEventHandler)
+
+	// FHIR R4 Observation ingestion endpoint (single resource or Bundle)
+	ingestion.POST("/fhir/Observation", fhirObservationHandler)
+
 	// Batch health events endpoint
-	router.POST("/health/events/batch", func(c *gin.Context) {
+	ingestion.POST("/events/batch", func(c *gin.Context) {
 		var events []HealthEvent
 		if err := c.ShouldBindJSON(&events); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
 			return
 		}
-		
+
+		if subject, ok := c.Get(subjectContextKey); ok {
+			for i := range events {
+				events[i].UserID = subject.(string)
+			}
+		}
+
 		results := make([]map[string]interface{}, 0, len(events))
 		for _, event := range events {
 			if err := publishHealthEvent(c.Request.Context(), event); err != nil {
@@ -366,14 +360,14 @@ func setupRouter() *gin.Engine {
 			} else {
 				results = append(results, map[string]interface{}{
 					"event_id": event.ID,
-					"status":   "success",
+					"status":   "accepted",
 				})
 			}
 		}
-		
+
 		c.JSON(http.StatusOK, gin.H{"results": results})
 	})
-	
+
 	return router
 }
 
@@ -381,27 +375,64 @@ func main() {
 	// Setup graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Start the consumer group in the background; it exits once ctx is
+	// canceled below.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go runConsumer(ctx, &wg)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startReadinessChecker(ctx)
+	}()
+
 	// Setup router
 	router := setupRouter()
-	
+
+	// Use a real http.Server (rather than router.Run) so shutdown can stop
+	// it from accepting new requests and drain in-flight ones before the
+	// publish queue is closed underneath them.
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.Server.Port),
+		Handler: router,
+	}
+
 	// Start server
 	go func() {
-		addr := fmt.Sprintf(":%d", config.Server.Port)
-		log.Infof("Starting ingestion service on %s", addr)
-		if err := router.Run(addr); err != nil {
+		log.Infof("Starting ingestion service on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
-	
+
 	// Wait for shutdown signal
 	<-quit
 	log.Info("Shutting down ingestion service...")
-	
-	// Cleanup
-	if producer != nil {
-		producer.Close()
+
+	// Stop accepting new requests and let in-flight ones finish before
+	// anything that serves them (the publish queue, the producer) is torn
+	// down, otherwise a request still running publishHealthEvent could
+	// send on a publishQueue that shutdownProducer has already closed.
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.Kafka.Producer.ShutdownDrainTimeout)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("Error shutting down HTTP server")
 	}
-	
+
+	// Stop the consumer and wait for it to exit before closing the
+	// producer it may still be forwarding failures through.
+	cancel()
+	wg.Wait()
+
+	// Cleanup: drain whatever is still queued before closing the producer.
+	shutdownProducer()
+	if kafkaClient != nil && !kafkaClient.Closed() {
+		kafkaClient.Close()
+	}
+
 	log.Info("Ingestion service stopped")
 } 
\ No newline at end of file
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	producer    sarama.AsyncProducer
+	kafkaClient sarama.Client
+
+	// publishQueue buffers messages between the HTTP handler and the
+	// dispatcher pool so a request never blocks on a Kafka round-trip.
+	publishQueue chan *sarama.ProducerMessage
+
+	// dispatchAbort is closed by shutdownProducer once the drain deadline
+	// passes, so a dispatchLoop blocked sending on producer.Input() gives
+	// up instead of racing AsyncClose() tearing down that channel.
+	dispatchAbort chan struct{}
+
+	dispatcherWG sync.WaitGroup
+	resultWG     sync.WaitGroup
+)
+
+// ErrQueueFull is returned by publishHealthEvent when publishQueue has no
+// spare capacity; callers should surface this as a 429 with Retry-After.
+var ErrQueueFull = errors.New("publish queue is full")
+
+var (
+	queueDepthGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ingestion_publish_queue_depth",
+		Help: "Current number of messages buffered in the publish queue",
+	})
+
+	publishBatchSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ingestion_publish_batch_size",
+			Help:    "Number of messages flushed to Kafka per batch",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		},
+		[]string{"topic"},
+	)
+
+	publishLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ingestion_publish_latency_seconds",
+			Help:    "Time between a message being enqueued and Kafka acking it",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"topic"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queueDepthGauge, publishBatchSize, publishLatency)
+}
+
+// messageMetadata rides along on sarama.ProducerMessage.Metadata so the
+// Successes()/Errors() drains can report per-message metrics without a
+// side table keyed by partition/offset.
+type messageMetadata struct {
+	enqueuedAt time.Time
+	eventID    string
+	eventType  string
+}
+
+// minLinger is the floor applied to kafka.producer.linger: both
+// time.NewTicker and sarama's Flush.Frequency panic/misbehave on a
+// non-positive duration, so a bad config value can't be allowed through.
+const minLinger = 10 * time.Millisecond
+
+func effectiveLinger() time.Duration {
+	if config.Kafka.Producer.Linger <= 0 {
+		return minLinger
+	}
+	return config.Kafka.Producer.Linger
+}
+
+func initKafkaProducer() error {
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.Producer.Return.Successes = true
+	kafkaConfig.Producer.Return.Errors = true
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	kafkaConfig.Producer.Retry.Max = 5
+	kafkaConfig.Producer.Flush.Messages = config.Kafka.Producer.BatchSize
+	kafkaConfig.Producer.Flush.Frequency = effectiveLinger()
+
+	applySASLConfig(kafkaConfig)
+
+	// Built from an explicit client (rather than sarama.NewAsyncProducer)
+	// so /readyz can call RefreshMetadata against the same connection the
+	// producer uses.
+	var err error
+	kafkaClient, err = sarama.NewClient(config.Kafka.Brokers, kafkaConfig)
+	if err != nil {
+		return err
+	}
+
+	producer, err = sarama.NewAsyncProducerFromClient(kafkaClient)
+	if err != nil {
+		return err
+	}
+
+	queueCapacity := config.Kafka.Producer.QueueCapacity
+	if queueCapacity < 1 {
+		queueCapacity = 1
+	}
+	publishQueue = make(chan *sarama.ProducerMessage, queueCapacity)
+	dispatchAbort = make(chan struct{})
+
+	resultWG.Add(2)
+	go drainSuccesses()
+	go drainErrors()
+
+	dispatchers := config.Kafka.Producer.Dispatchers
+	if dispatchers < 1 {
+		dispatchers = 1
+	}
+	dispatcherWG.Add(dispatchers)
+	for i := 0; i < dispatchers; i++ {
+		go dispatchLoop()
+	}
+
+	log.Info("Kafka async producer initialized successfully")
+	return nil
+}
+
+// dispatchLoop drains publishQueue, batching messages by topic until
+// batch_size is reached or linger elapses, then hands each message to the
+// producer's Input() channel. Multiple dispatchers run concurrently,
+// each with its own per-topic batch buffers.
+func dispatchLoop() {
+	defer dispatcherWG.Done()
+
+	batchSize := config.Kafka.Producer.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	batches := make(map[string][]*sarama.ProducerMessage)
+	flush := func(topic string) {
+		batch := batches[topic]
+		if len(batch) == 0 {
+			return
+		}
+		publishBatchSize.WithLabelValues(topic).Observe(float64(len(batch)))
+		for _, msg := range batch {
+			select {
+			case producer.Input() <- msg:
+			case <-dispatchAbort:
+				// shutdownProducer gave up waiting on us; stop sending so
+				// we don't race AsyncClose() tearing down Input().
+				healthEventsErrors.WithLabelValues("shutdown_drain_aborted").Inc()
+				log.WithField("topic", topic).Warn("Shutdown deadline exceeded, dropping buffered messages still queued for send")
+				batches[topic] = batch[:0]
+				return
+			}
+		}
+		batches[topic] = batch[:0]
+	}
+
+	ticker := time.NewTicker(effectiveLinger())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-publishQueue:
+			if !ok {
+				for topic := range batches {
+					flush(topic)
+				}
+				return
+			}
+			queueDepthGauge.Set(float64(len(publishQueue)))
+			batches[msg.Topic] = append(batches[msg.Topic], msg)
+			if len(batches[msg.Topic]) >= batchSize {
+				flush(msg.Topic)
+			}
+		case <-ticker.C:
+			for topic := range batches {
+				flush(topic)
+			}
+		}
+	}
+}
+
+func drainSuccesses() {
+	defer resultWG.Done()
+	for msg := range producer.Successes() {
+		meta, _ := msg.Metadata.(*messageMetadata)
+		if meta == nil {
+			continue
+		}
+		publishLatency.WithLabelValues(msg.Topic).Observe(time.Since(meta.enqueuedAt).Seconds())
+		healthEventsPublished.WithLabelValues(msg.Topic, meta.eventType).Inc()
+		log.WithFields(logrus.Fields{
+			"event_id":   meta.eventID,
+			"event_type": meta.eventType,
+			"topic":      msg.Topic,
+			"partition":  msg.Partition,
+			"offset":     msg.Offset,
+		}).Info("Health event published to Kafka")
+	}
+}
+
+func drainErrors() {
+	defer resultWG.Done()
+	for errMsg := range producer.Errors() {
+		healthEventsErrors.WithLabelValues("kafka_publish").Inc()
+		payload, _ := errMsg.Msg.Value.Encode()
+		log.WithError(errMsg.Err).WithField("topic", errMsg.Msg.Topic).Error("Failed to publish to Kafka, forwarding to dead-letter topic")
+		sendToDeadLetter(errMsg.Msg.Topic, payload, errMsg.Err.Error())
+	}
+}
+
+// shutdownProducer stops accepting new messages, gives the dispatcher pool
+// a bounded deadline to hand off whatever is already queued, aborting any
+// dispatcher still blocked on producer.Input() past that deadline so none
+// of them are mid-send when the producer is closed, then closes the
+// producer and waits for the success/error drains to finish so no
+// in-flight acknowledgment is lost.
+func shutdownProducer() {
+	if publishQueue == nil {
+		return
+	}
+
+	close(publishQueue)
+
+	drained := make(chan struct{})
+	go func() {
+		dispatcherWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(config.Kafka.Producer.ShutdownDrainTimeout):
+		log.Warn("Timed out draining publish queue during shutdown; aborting in-flight dispatch sends")
+		// Tell dispatchLoop goroutines blocked on producer.Input() <- msg
+		// to give up so none of them are still sending when AsyncClose
+		// tears that channel down below.
+		close(dispatchAbort)
+		<-drained
+	}
+
+	if producer != nil {
+		producer.AsyncClose()
+	}
+	resultWG.Wait()
+}
+
+func getTopicForCategory(category string) string {
+	switch category {
+	case "activity":
+		return config.Kafka.Topics.Activity
+	case "heart_rate":
+		return config.Kafka.Topics.HeartRate
+	case "sleep":
+		return config.Kafka.Topics.Sleep
+	case "nutrition":
+		return config.Kafka.Topics.Nutrition
+	case "weight":
+		return config.Kafka.Topics.Weight
+	case "mood":
+		return config.Kafka.Topics.Mood
+	case "hydration":
+		return config.Kafka.Topics.Hydration
+	case "meditation":
+		return config.Kafka.Topics.Meditation
+	default:
+		return "health.unknown.raw"
+	}
+}
+
+// resolveTopic maps an event's type to a Kafka topic using the operator
+// configured kafka.producer.topic_mapping. Patterns are matched with
+// path.Match (e.g. "health.activity.*"); when several patterns match, the
+// longest (most specific) pattern wins. Falls back to default_topic and
+// finally to the static per-category topics when nothing matches.
+func resolveTopic(event HealthEvent) string {
+	mapping := config.Kafka.Producer.TopicMapping
+	if len(mapping) > 0 {
+		patterns := make([]string, 0, len(mapping))
+		for pattern := range mapping {
+			if ok, err := path.Match(pattern, event.EventType); err == nil && ok {
+				patterns = append(patterns, pattern)
+			}
+		}
+		if len(patterns) > 0 {
+			sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+			return mapping[patterns[0]]
+		}
+	}
+
+	if config.Kafka.Producer.DefaultTopic != "" {
+		return config.Kafka.Producer.DefaultTopic
+	}
+
+	return getTopicForCategory(event.Category)
+}
+
+// sendToDeadLetter forwards a message that failed marshaling, validation,
+// or publishing to the configured dead-letter topic, preserving the
+// original topic and failure reason as headers so repair tooling can
+// triage without re-parsing the payload. It bypasses publishQueue and
+// writes straight to the producer's input so a full publish queue can't
+// also block dead-lettering; if that input is itself full the message is
+// dropped and logged rather than risking a deadlock.
+func sendToDeadLetter(originalTopic string, payload []byte, reason string) {
+	dlq := config.Kafka.Producer.DeadLetterTopic
+	if dlq == "" || producer == nil {
+		return
+	}
+
+	message := &sarama.ProducerMessage{
+		Topic: dlq,
+		Value: sarama.ByteEncoder(payload),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("original_topic"), Value: []byte(originalTopic)},
+			{Key: []byte("failure_reason"), Value: []byte(reason)},
+		},
+	}
+
+	select {
+	case producer.Input() <- message:
+	default:
+		log.WithField("original_topic", originalTopic).Error("Dead-letter topic input is full, dropping message")
+	}
+}
+
+func publishHealthEvent(ctx context.Context, event HealthEvent) error {
+	// Increment received events metric
+	healthEventsReceived.WithLabelValues(event.EventType, event.Source).Inc()
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	event.CreatedAt = time.Now()
+
+	if err := event.validate(); err != nil {
+		healthEventsErrors.WithLabelValues("validation").Inc()
+		raw, _ := json.Marshal(event)
+		sendToDeadLetter(resolveTopic(event), raw, err.Error())
+		return fmt.Errorf("event failed validation: %w", err)
+	}
+
+	cloudEvent := toCloudEvent(event)
+	eventJSON, err := json.Marshal(cloudEvent)
+	if err != nil {
+		healthEventsErrors.WithLabelValues("json_marshal").Inc()
+		sendToDeadLetter(resolveTopic(event), eventJSON, err.Error())
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	topic := resolveTopic(event)
+
+	message := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(event.UserID),
+		Value: sarama.ByteEncoder(eventJSON),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("ce_type"), Value: []byte(cloudEvent.Type)},
+			{Key: []byte("ce_source"), Value: []byte(cloudEvent.Source)},
+			{Key: []byte("event_type"), Value: []byte(event.EventType)},
+			{Key: []byte("category"), Value: []byte(event.Category)},
+			{Key: []byte("source"), Value: []byte(event.Source)},
+			{Key: []byte("timestamp"), Value: []byte(event.Timestamp.Format(time.RFC3339))},
+		},
+		Metadata: &messageMetadata{
+			enqueuedAt: time.Now(),
+			eventID:    event.ID,
+			eventType:  event.EventType,
+		},
+	}
+
+	select {
+	case publishQueue <- message:
+		queueDepthGauge.Set(float64(len(publishQueue)))
+	default:
+		healthEventsErrors.WithLabelValues("queue_full").Inc()
+		sendToDeadLetter(topic, eventJSON, ErrQueueFull.Error())
+		return ErrQueueFull
+	}
+
+	log.WithFields(logrus.Fields{
+		"event_id":   event.ID,
+		"user_id":    event.UserID,
+		"event_type": event.EventType,
+		"category":   event.Category,
+		"topic":      topic,
+	}).Debug("Health event enqueued for publishing")
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func withTopicMapping(t *testing.T, mapping map[string]string, defaultTopic string) {
+	t.Helper()
+	origMapping := config.Kafka.Producer.TopicMapping
+	origDefault := config.Kafka.Producer.DefaultTopic
+	config.Kafka.Producer.TopicMapping = mapping
+	config.Kafka.Producer.DefaultTopic = defaultTopic
+	t.Cleanup(func() {
+		config.Kafka.Producer.TopicMapping = origMapping
+		config.Kafka.Producer.DefaultTopic = origDefault
+	})
+}
+
+func TestResolveTopicWildcardPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		mapping   map[string]string
+		eventType string
+		want      string
+	}{
+		{
+			name: "longest matching pattern wins",
+			mapping: map[string]string{
+				"health.*":          "health.catch_all",
+				"health.activity.*": "health.activity.mapped",
+			},
+			eventType: "health.activity.steps",
+			want:      "health.activity.mapped",
+		},
+		{
+			name: "exact match is preferred over a wildcard",
+			mapping: map[string]string{
+				"health.activity.*":     "health.activity.mapped",
+				"health.activity.steps": "health.activity.steps.exact",
+			},
+			eventType: "health.activity.steps",
+			want:      "health.activity.steps.exact",
+		},
+		{
+			name: "no pattern matches falls back to static category topic",
+			mapping: map[string]string{
+				"health.sleep.*": "health.sleep.mapped",
+			},
+			eventType: "health.activity.steps",
+			want:      "health.activity.raw",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withTopicMapping(t, tt.mapping, "")
+			got := resolveTopic(HealthEvent{EventType: tt.eventType, Category: "activity"})
+			if got != tt.want {
+				t.Errorf("resolveTopic(%q) = %q, want %q", tt.eventType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTopicDefaultTopicFallback(t *testing.T) {
+	withTopicMapping(t, map[string]string{"health.sleep.*": "health.sleep.mapped"}, "health.default.raw")
+
+	got := resolveTopic(HealthEvent{EventType: "health.activity.steps", Category: "activity"})
+	if got != "health.default.raw" {
+		t.Errorf("resolveTopic() = %q, want default_topic %q", got, "health.default.raw")
+	}
+}
+
+func TestResolveTopicStaticCategoryFallback(t *testing.T) {
+	withTopicMapping(t, nil, "")
+
+	origSleep := config.Kafka.Topics.Sleep
+	config.Kafka.Topics.Sleep = "health.sleep.raw"
+	t.Cleanup(func() { config.Kafka.Topics.Sleep = origSleep })
+
+	got := resolveTopic(HealthEvent{EventType: "anything", Category: "sleep"})
+	if got != "health.sleep.raw" {
+		t.Errorf("resolveTopic() = %q, want static topic %q", got, "health.sleep.raw")
+	}
+}
+
+func TestResolveTopicUnknownCategoryRoutesToUnknownRaw(t *testing.T) {
+	withTopicMapping(t, nil, "")
+
+	got := resolveTopic(HealthEvent{EventType: "anything", Category: "not_a_real_category"})
+	if got != "health.unknown.raw" {
+		t.Errorf("resolveTopic() = %q, want %q", got, "health.unknown.raw")
+	}
+}
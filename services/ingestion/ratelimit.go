@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// tenantRateLimiter hands out an independent token bucket per tenant so
+// one noisy tenant can't exhaust another's quota. Buckets are created
+// lazily on first use and share the configured rps/burst.
+type tenantRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	rps      rate.Limit
+	burst    int
+}
+
+var tenantLimiter = &tenantRateLimiter{limiters: make(map[string]*rate.Limiter)}
+
+func (t *tenantRateLimiter) configure(rps float64, burst int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rps = rate.Limit(rps)
+	t.burst = burst
+}
+
+func (t *tenantRateLimiter) allow(tenant string) bool {
+	t.mu.Lock()
+	limiter, ok := t.limiters[tenant]
+	if !ok {
+		limiter = rate.NewLimiter(t.rps, t.burst)
+		t.limiters[tenant] = limiter
+	}
+	t.mu.Unlock()
+
+	return limiter.Allow()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestTenantRateLimiterPerTenantIsolation(t *testing.T) {
+	limiter := &tenantRateLimiter{limiters: make(map[string]*rate.Limiter)}
+	limiter.configure(1, 1)
+
+	if !limiter.allow("tenant-a") {
+		t.Fatal("expected first request for tenant-a to be allowed")
+	}
+	if limiter.allow("tenant-a") {
+		t.Fatal("expected second immediate request for tenant-a to be rate limited")
+	}
+	if !limiter.allow("tenant-b") {
+		t.Fatal("expected tenant-b to have its own independent bucket")
+	}
+}
+
+func TestTenantRateLimiterBurstAllowsUpToBucketSize(t *testing.T) {
+	limiter := &tenantRateLimiter{limiters: make(map[string]*rate.Limiter)}
+	limiter.configure(0, 3)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("tenant-a") {
+			t.Fatalf("expected request %d within burst to be allowed", i+1)
+		}
+	}
+	if limiter.allow("tenant-a") {
+		t.Fatal("expected request beyond burst to be rate limited")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var kafkaHealthyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "ingestion_kafka_healthy",
+	Help: "1 if the ingestion service can reach its Kafka brokers, 0 otherwise",
+})
+
+func init() {
+	prometheus.MustRegister(kafkaHealthyGauge)
+}
+
+const readinessCheckInterval = 10 * time.Second
+
+// jaegerHealthy is set to true by initTracer and to false by the error
+// handler it installs, on any OpenTelemetry error. A later successful
+// export does not set it back to true: once false, it stays false until
+// the process restarts.
+var jaegerHealthy atomic.Bool
+
+// readinessState caches the outcome of the last Kafka metadata refresh so
+// /readyz never blocks an HTTP request on a broker round-trip.
+type readinessState struct {
+	mu           sync.RWMutex
+	kafkaHealthy bool
+	lastErr      error
+	lastChecked  time.Time
+}
+
+var readiness = &readinessState{}
+
+func (r *readinessState) set(healthy bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kafkaHealthy = healthy
+	r.lastErr = err
+	r.lastChecked = time.Now()
+
+	if healthy {
+		kafkaHealthyGauge.Set(1)
+	} else {
+		kafkaHealthyGauge.Set(0)
+	}
+}
+
+func (r *readinessState) snapshot() (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.kafkaHealthy, r.lastErr
+}
+
+// startReadinessChecker refreshes Kafka broker metadata on a ticker until
+// ctx is canceled, caching the result in readiness.
+func startReadinessChecker(ctx context.Context) {
+	checkKafkaHealth()
+
+	ticker := time.NewTicker(readinessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checkKafkaHealth()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func checkKafkaHealth() {
+	if kafkaClient == nil || kafkaClient.Closed() {
+		readiness.set(false, fmt.Errorf("kafka client is closed"))
+		return
+	}
+
+	if err := kafkaClient.RefreshMetadata(allTopics()...); err != nil {
+		readiness.set(false, err)
+		return
+	}
+
+	readiness.set(true, nil)
+}
+
+func livezHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "alive",
+		"service": "ingestion-service",
+	})
+}
+
+func readyzHandler(c *gin.Context) {
+	kafkaOK, kafkaErr := readiness.snapshot()
+	jaegerOK := jaegerHealthy.Load()
+
+	body := gin.H{
+		"status":         "ready",
+		"service":        "ingestion-service",
+		"kafka_healthy":  kafkaOK,
+		"jaeger_healthy": jaegerOK,
+	}
+
+	if !kafkaOK || !jaegerOK {
+		body["status"] = "not_ready"
+		if kafkaErr != nil {
+			body["kafka_error"] = kafkaErr.Error()
+		}
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// applySASLConfig wires kafka.sasl settings onto a sarama config so both
+// the producer and consumer authenticate to the broker the same way.
+func applySASLConfig(cfg *sarama.Config) {
+	if !config.Kafka.SASL.Enabled {
+		return
+	}
+
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = config.Kafka.SASL.User
+	cfg.Net.SASL.Password = config.Kafka.SASL.Password
+
+	switch config.Kafka.SASL.Mechanism {
+	case "SCRAM-SHA-512":
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha512HashGenerator}
+		}
+	case "SCRAM-SHA-256":
+		cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{HashGeneratorFcn: sha256HashGenerator}
+		}
+	default:
+		cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	}
+}
+
+var (
+	sha256HashGenerator scram.HashGeneratorFcn = sha256.New
+	sha512HashGenerator scram.HashGeneratorFcn = sha512.New
+)
+
+// scramClient adapts github.com/xdg-go/scram to sarama's SCRAMClient
+// interface so the producer can authenticate to Kafka via SASL/SCRAM.
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.Client = client
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}